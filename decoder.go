@@ -2,6 +2,7 @@ package xmlutil
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/xml"
 	"errors"
 	"io"
@@ -24,6 +25,21 @@ func (typeError *UnknownTypeError) Error() (msg string) {
 	return
 }
 
+// Unmarshaler is implemented by types that can unmarshal an XML element
+// description of themselves, analogous to encoding/xml's xml.Unmarshaler.
+// UnmarshalXML must consume exactly one XML element, including the
+// closing end element, e.g. via d.Skip().
+type Unmarshaler interface {
+	UnmarshalXML(d *Decoder, start xml.StartElement) error
+}
+
+// UnmarshalerAttr is implemented by types that can unmarshal an XML
+// attribute description of themselves, analogous to encoding/xml's
+// xml.UnmarshalerAttr.
+type UnmarshalerAttr interface {
+	UnmarshalXMLAttr(attr xml.Attr) error
+}
+
 func (x *XmlUtil) Unmarshal(data []byte, v interface{}) error {
 	return x.NewDecoder(bytes.NewBuffer(data)).Decode(v)
 }
@@ -31,10 +47,23 @@ func (x *XmlUtil) Unmarshal(data []byte, v interface{}) error {
 type Decoder struct {
 	xmlutil *XmlUtil
 	parser  *xml.Decoder
+	// recorders is a stack of in-flight ",innerxml" and PropertyBag
+	// captures. Every token read through readToken is tee'd to each of
+	// them, so that nested captures (an ",innerxml" field inside another)
+	// see the tokens too.
+	recorders []*decoderRecorder
+}
+
+// decoderRecorder reconstructs the serialized form of the tokens it is fed
+// via an ordinary Encoder, so ",innerxml" works against any io.Reader, not
+// just the ones that make their raw bytes cheaply available.
+type decoderRecorder struct {
+	enc *Encoder
+	buf *bytes.Buffer
 }
 
 func (x *XmlUtil) NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{x, xml.NewDecoder(r)}
+	return &Decoder{xmlutil: x, parser: xml.NewDecoder(r)}
 }
 
 func (d *Decoder) Decode(v interface{}) error {
@@ -65,7 +94,7 @@ func (d *Decoder) DecodeElement(v interface{}, start *xml.StartElement) error {
 
 func (d *Decoder) Find(names []xml.Name) (*xml.StartElement, error) {
 	for {
-		tok, err := d.parser.Token()
+		tok, err := d.readToken()
 		if err != nil {
 			return nil, err
 		}
@@ -79,44 +108,181 @@ func (d *Decoder) Find(names []xml.Name) (*xml.StartElement, error) {
 	}
 }
 
-func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
-	if start == nil {
-		for {
-			tok, err := d.parser.Token()
-			if err != nil {
-				return err
+// Token returns the next XML token in the stream, allowing Unmarshaler
+// implementations to consume raw tokens directly.
+func (d *Decoder) Token() (xml.Token, error) {
+	return d.readToken()
+}
+
+// readToken reads the next token from the underlying parser, tee-ing it to
+// any in-flight recorders before returning it. Every token consumed
+// anywhere below DecodeElement either goes through here or is tee'd
+// explicitly (unmarshalFields and skipCapture read d.parser.Token()
+// directly so they can pop their own recorder before tee-ing their closing
+// end element), so that content nested arbitrarily deep below an
+// ",innerxml" field still ends up in its capture.
+func (d *Decoder) readToken() (xml.Token, error) {
+	tok, err := d.parser.Token()
+	if err != nil {
+		return nil, err
+	}
+	d.tee(tok)
+	return tok, nil
+}
+
+// tee writes tok into every currently active recorder, both ",innerxml"
+// and PropertyBag captures.
+func (d *Decoder) tee(tok xml.Token) {
+	for _, r := range d.recorders {
+		r.enc.EncodeToken(tok)
+	}
+}
+
+func (d *Decoder) pushRecorder() *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	r := &decoderRecorder{enc: d.xmlutil.NewEncoder(buf), buf: buf}
+	d.recorders = append(d.recorders, r)
+	return buf
+}
+
+func (d *Decoder) popRecorder() {
+	n := len(d.recorders) - 1
+	d.recorders[n].enc.Flush()
+	d.recorders = d.recorders[:n]
+}
+
+// Skip reads tokens until it has consumed the end element matching the
+// most recently read start element. It is meant to be called by an
+// Unmarshaler that wants to ignore the rest of its element's content.
+func (d *Decoder) Skip() error {
+	depth := 0
+	for {
+		tok, err := d.readToken()
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return nil
 			}
-			if t, ok := tok.(xml.StartElement); ok {
-				start = &t
-				break
+			depth--
+		}
+	}
+}
+
+func (d *Decoder) readStart() (*xml.StartElement, error) {
+	for {
+		tok, err := d.readToken()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := tok.(xml.StartElement); ok {
+			return &t, nil
+		}
+	}
+}
+
+// readCharData reads tokens until the end element matching the most
+// recently read start element, returning the concatenated character data
+// found directly inside it and ignoring any nested tags.
+func (d *Decoder) readCharData() ([]byte, error) {
+	var data []byte
+	depth := 0
+	for {
+		tok, err := d.readToken()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.CharData:
+			if depth == 0 {
+				data = append(data, t...)
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				return data, nil
 			}
+			depth--
+		}
+	}
+}
+
+func unmarshalerFor(val reflect.Value) (Unmarshaler, bool) {
+	if val.CanAddr() {
+		if u, ok := val.Addr().Interface().(Unmarshaler); ok {
+			return u, true
+		}
+	}
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		if u, ok := val.Interface().(Unmarshaler); ok {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// textUnmarshalerFor is unmarshalerFor's counterpart for
+// encoding.TextUnmarshaler, used to decode values like net.IP or
+// big.Int whose textual form doesn't merit a whole element structure.
+func textUnmarshalerFor(val reflect.Value) (encoding.TextUnmarshaler, bool) {
+	if val.CanAddr() {
+		if u, ok := val.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u, true
+		}
+	}
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		if u, ok := val.Interface().(encoding.TextUnmarshaler); ok {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
+	return d.unmarshalFlags(val, start, 0)
+}
+
+// unmarshalFlags is unmarshal with the originating fieldInfo's flags
+// threaded through, so that kind-specific decoding (currently just
+// reflect.Interface) can see tag options like ",any" that only make sense
+// in the context of a particular struct field.
+func (d *Decoder) unmarshalFlags(val reflect.Value, start *xml.StartElement, flags fieldFlags) error {
+	if start == nil {
+		t, err := d.readStart()
+		if err != nil {
+			return err
+		}
+		start = t
+	}
+
+	if u, ok := unmarshalerFor(val); ok {
+		return u.UnmarshalXML(d, *start)
+	}
+
+	if u, ok := textUnmarshalerFor(val); ok {
+		data, err := d.readCharData()
+		if err != nil {
+			return err
 		}
+		return u.UnmarshalText(data)
 	}
 
 	switch val.Kind() {
 	default:
-		var data []byte
-		depth := 0
-	Loop:
-		for {
-			tok, err := d.parser.Token()
-			if err != nil {
-				return err
-			}
-			switch t := tok.(type) {
-			case xml.StartElement:
-				depth++
-			case xml.CharData:
-				// Shallow copy value, ignore nested tags
-				if depth == 0 {
-					data = append(data, t...)
-				}
-			case xml.EndElement:
-				if depth == 0 {
-					break Loop
-				}
-				depth--
-			}
+		data, err := d.readCharData()
+		if err != nil {
+			return err
 		}
 		copyValue(val, data)
 	case reflect.Struct:
@@ -136,16 +302,18 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 			val.Set(nval)
 		}
 		val.SetLen(n + 1)
-		err := d.unmarshal(val.Index(n), start)
+		err := d.unmarshalFlags(val.Index(n), start, flags)
 		if err != nil {
 			val.SetLen(n)
 			return err
 		}
 	case reflect.Interface:
-		println(start.Name.Local)
 		ntyp := d.xmlutil.getTypeByName(start.Name)
 		if ntyp == nil {
-			break
+			if flags&fAny != 0 {
+				return d.Skip()
+			}
+			return &UnknownTypeError{start.Name}
 		}
 		nval := reflect.New(ntyp).Elem()
 		err := d.unmarshal(nval, start)
@@ -157,7 +325,7 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 		if val.IsNil() {
 			val.Set(reflect.New(val.Type().Elem()))
 		}
-		err := d.unmarshal(val.Elem(), start)
+		err := d.unmarshalFlags(val.Elem(), start, flags)
 		if err != nil {
 			return err
 		}
@@ -171,6 +339,27 @@ func (d *Decoder) unmarshalFields(val reflect.Value, start *xml.StartElement) er
 	if err != nil {
 		return err
 	}
+
+	var chardataFI, innerXmlFI, anyFI, bagFI *fieldInfo
+	for i := range ti.fields {
+		switch {
+		case ti.fields[i].flags&fChardata != 0:
+			chardataFI = &ti.fields[i]
+		case ti.fields[i].flags&fInnerXml != 0:
+			innerXmlFI = &ti.fields[i]
+		case ti.fields[i].flags&fPropertyBag != 0:
+			bagFI = &ti.fields[i]
+		case ti.fields[i].flags&fAny != 0:
+			anyFI = &ti.fields[i]
+		}
+	}
+
+	var chardata []byte
+	var innerBuf *bytes.Buffer
+	if innerXmlFI != nil {
+		innerBuf = d.pushRecorder()
+	}
+
 	for _, attr := range start.Attr {
 		for _, fi := range ti.fields {
 			if fi.flags&fAttr == 0 {
@@ -178,6 +367,14 @@ func (d *Decoder) unmarshalFields(val reflect.Value, start *xml.StartElement) er
 			}
 			if fi.name == attr.Name {
 				fval := val.Field(fi.index)
+				if fval.CanAddr() {
+					if u, ok := fval.Addr().Interface().(UnmarshalerAttr); ok {
+						if err := u.UnmarshalXMLAttr(attr); err != nil {
+							return err
+						}
+						break
+					}
+				}
 				err := copyValue(fval, []byte(attr.Value))
 				if err != nil {
 					return err
@@ -193,6 +390,9 @@ Loop:
 		if err != nil {
 			return err
 		}
+		if _, ok := tok.(xml.EndElement); !ok {
+			d.tee(tok)
+		}
 		switch t := tok.(type) {
 		case xml.StartElement:
 			// Fix when document doesn't declare namespace
@@ -202,46 +402,212 @@ Loop:
 			}
 			// Find a match in field for start tag
 			for _, fi := range ti.fields {
-				if fi.flags&fAttr != 0 {
+				if fi.flags&(fAttr|fChardata|fInnerXml|fComment) != 0 {
 					continue
 				}
+				if len(fi.parents) > 0 && fi.parents[0] == t.Name.Local {
+					if err := d.unmarshalNested(val, ti.fields, 1); err != nil {
+						return err
+					}
+					continue Loop
+				}
 				if fi.name == t.Name || fi.flags&fInterface != 0 {
 					fval := val.Field(fi.index)
 					if !fval.IsValid() {
 						continue Loop
 					}
-					err := d.unmarshal(fval, &t)
+					if fi.flags&fPropertyBag != 0 {
+						if err := d.unmarshalBagWrapper(fval); err != nil {
+							return err
+						}
+						continue Loop
+					}
+					err := d.unmarshalFlags(fval, &t, fi.flags)
 					if err != nil {
 						return err
 					}
 					continue Loop
 				}
 			}
-			// Couldn't find match, so eat the rest and continue
-			depth := 0
-			for {
-				tok, err := d.parser.Token()
-				if err != nil {
+			if bagFI != nil && (!bagFI.explicitName || bagFI.flags&fAny != 0) {
+				if err := d.unmarshalIntoBag(val.Field(bagFI.index), t); err != nil {
 					return err
 				}
-				switch tok.(type) {
-				case xml.StartElement:
-					depth++
-				case xml.EndElement:
-					if depth == 0 {
-						continue Loop
-					}
-					depth--
+				continue Loop
+			}
+			if anyFI != nil {
+				fval := val.Field(anyFI.index)
+				if err := d.unmarshal(fval, &t); err != nil {
+					return err
 				}
+				continue Loop
+			}
+			// Couldn't find match, so eat the rest and continue
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.CharData:
+			if chardataFI != nil {
+				chardata = append(chardata, t...)
 			}
 		case xml.EndElement:
+			if chardataFI != nil {
+				if err := copyValue(val.Field(chardataFI.index), chardata); err != nil {
+					return err
+				}
+			}
+			if innerXmlFI != nil {
+				d.popRecorder()
+				if err := copyValue(val.Field(innerXmlFI.index), innerBuf.Bytes()); err != nil {
+					return err
+				}
+			}
+			// This end element closes val's own start tag, not a nested
+			// one (those are fully consumed above before we see them), so
+			// it belongs to an enclosing ",innerxml" capture, if any, but
+			// never to the one just popped above.
+			d.tee(t)
 			break Loop
 		}
 	}
 	return nil
 }
 
+// unmarshalNested decodes the children of a ">"-wrapped group of fields.
+// depth is how many levels of wrapper have already been matched by the
+// caller (e.g. depth 1 once just inside <a>). It matches every field of
+// the enclosing struct whose parents chain agrees with the wrapper chain
+// this far, not just the single field that happened to trigger the first
+// descent, so sibling fields sharing a wrapper prefix (xml:"a>foo" and
+// xml:"a>bar") are both matched against the one open <a> instead of the
+// first match claiming the whole subtree.
+func (d *Decoder) unmarshalNested(val reflect.Value, fields []fieldInfo, depth int) error {
+	for {
+		tok, err := d.readToken()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := d.dispatchNested(val, fields, depth, t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// dispatchNested matches a single StartElement encountered depth levels
+// inside a ">"-wrapped group against fields, descending into a further
+// wrapper level or decoding a leaf field as appropriate, and skipping the
+// element if nothing matches.
+func (d *Decoder) dispatchNested(val reflect.Value, fields []fieldInfo, depth int, t xml.StartElement) error {
+	for _, fi := range fields {
+		if len(fi.parents) > depth && fi.parents[depth] == t.Name.Local {
+			return d.unmarshalNested(val, fields, depth+1)
+		}
+	}
+	for _, fi := range fields {
+		if len(fi.parents) != depth {
+			continue
+		}
+		if fi.name != t.Name && fi.flags&fInterface == 0 {
+			continue
+		}
+		fval := val.Field(fi.index)
+		if !fval.IsValid() {
+			return d.Skip()
+		}
+		if fi.flags&fPropertyBag != 0 {
+			return d.unmarshalBagWrapper(fval)
+		}
+		return d.unmarshalFlags(fval, &t, fi.flags)
+	}
+	return d.Skip()
+}
+
+// unmarshalIntoBag routes a child element that didn't match any named
+// field into a PropertyBag field: if t's name is a registered type, it is
+// decoded into a fresh value of that type, otherwise its inner XML is
+// captured verbatim as RawXML.
+func (d *Decoder) unmarshalIntoBag(fval reflect.Value, t xml.StartElement) error {
+	if !fval.CanAddr() {
+		return errors.New("xmlutil: PropertyBag field must be addressable")
+	}
+	bag := fval.Addr().Interface().(*PropertyBag)
+
+	if ntyp := d.xmlutil.getTypeByName(t.Name); ntyp != nil {
+		nval := reflect.New(ntyp).Elem()
+		if err := d.unmarshal(nval, &t); err != nil {
+			return err
+		}
+		bag.Set(t.Name, nval.Interface())
+		return nil
+	}
+
+	raw, err := d.skipCapture()
+	if err != nil {
+		return err
+	}
+	bag.Set(t.Name, RawXML(raw))
+	return nil
+}
+
+// unmarshalBagWrapper decodes the children of the element just matched by
+// the PropertyBag field's own tag, such as a WebDAV <prop> block, into the
+// bag, the same way unmarshalIntoBag handles a single unmatched child.
+func (d *Decoder) unmarshalBagWrapper(fval reflect.Value) error {
+	for {
+		tok, err := d.readToken()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := d.unmarshalIntoBag(fval, t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// skipCapture is like Skip, but also returns the bytes consumed (the
+// element's inner content, not including its own start/end tags),
+// reconstructed through the same recorder/tee mechanism ",innerxml" uses so
+// it works for any source reader, not just ones that make their raw bytes
+// cheaply available.
+func (d *Decoder) skipCapture() ([]byte, error) {
+	buf := d.pushRecorder()
+	depth := 0
+	for {
+		tok, err := d.parser.Token()
+		if err != nil {
+			return nil, err
+		}
+		if end, ok := tok.(xml.EndElement); ok && depth == 0 {
+			d.popRecorder()
+			d.tee(end)
+			return append([]byte(nil), buf.Bytes()...), nil
+		}
+		d.tee(tok)
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
 func copyValue(dst reflect.Value, src []byte) error {
+	if dst.CanAddr() {
+		if u, ok := dst.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText(src)
+		}
+	}
 	switch t := dst; t.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		value, err := strconv.ParseInt(string(src), 10, 64)
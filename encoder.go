@@ -3,13 +3,19 @@ package xmlutil
 import (
 	"bufio"
 	"bytes"
+	"encoding"
 	"encoding/xml"
+	"errors"
 	"io"
 	"reflect"
 	"strconv"
 	"time"
 )
 
+// Header is a generic XML header suitable for use with the output of Marshal.
+// It matches the header used by encoding/xml.
+const Header = xml.Header
+
 type UnsupportedTypeError struct {
 	Type reflect.Type
 }
@@ -18,6 +24,21 @@ func (typeError *UnsupportedTypeError) Error() string {
 	return "xmlutil: unsupported type: " + typeError.Type.String()
 }
 
+// Marshaler is implemented by types that can marshal themselves into valid
+// XML by writing tokens through the Encoder. MarshalXML is called with a
+// starting element whose Name and Attr the implementation may use or
+// override, and it is responsible for writing matching start and end
+// elements.
+type Marshaler interface {
+	MarshalXML(e *Encoder, start xml.StartElement) error
+}
+
+// MarshalerAttr is implemented by types that can marshal themselves as an
+// XML attribute, analogous to encoding/xml's xml.MarshalerAttr.
+type MarshalerAttr interface {
+	MarshalXMLAttr(name xml.Name) (xml.Attr, error)
+}
+
 func (x *XmlUtil) Marshal(v interface{}) ([]byte, error) {
 	var b bytes.Buffer
 	if err := x.NewEncoder(&b).Encode(v); err != nil {
@@ -26,13 +47,39 @@ func (x *XmlUtil) Marshal(v interface{}) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// encoderFrame tracks an open element on the Encoder's tag stack so that
+// EncodeToken can validate matching end elements and decide where
+// indentation is needed.
+type encoderFrame struct {
+	name     xml.Name
+	hasChild bool
+	hasText  bool
+}
+
 type Encoder struct {
 	xmlutil *XmlUtil
 	writer  *bufio.Writer
+	stack   []encoderFrame
+	prefix  string
+	indent  string
 }
 
 func (x *XmlUtil) NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{x, bufio.NewWriter(w)}
+	return &Encoder{xmlutil: x, writer: bufio.NewWriter(w)}
+}
+
+// Indent sets the encoder to generate output in which each element begins
+// on a new line and is indented by one or more copies of indent according
+// to its nesting depth, prefixed by prefix. It matches encoding/xml's
+// Encoder.Indent.
+func (e *Encoder) Indent(prefix, indent string) {
+	e.prefix = prefix
+	e.indent = indent
+}
+
+// Flush flushes any buffered output to the underlying writer.
+func (e *Encoder) Flush() error {
+	return e.writer.Flush()
 }
 
 func (e *Encoder) Encode(v interface{}) error {
@@ -41,6 +88,157 @@ func (e *Encoder) Encode(v interface{}) error {
 	return err
 }
 
+// EncodeToken writes the given XML token to the stream. It maintains an
+// internal stack of open elements so that StartElement/EndElement pairs can
+// be validated and so that Marshaler implementations can stream arbitrary,
+// possibly mixed, content.
+func (e *Encoder) EncodeToken(t xml.Token) error {
+	switch t := t.(type) {
+	case xml.StartElement:
+		return e.encodeStart(t)
+	case xml.EndElement:
+		return e.encodeEnd(t.Name)
+	case xml.CharData:
+		xml.Escape(e.writer, t)
+		if len(e.stack) > 0 {
+			e.stack[len(e.stack)-1].hasText = true
+		}
+		return nil
+	case xml.Comment:
+		e.writeIndent()
+		e.markParentChild()
+		e.writer.WriteString("<!--")
+		e.writer.Write(t)
+		e.writer.WriteString("-->")
+		return nil
+	case xml.ProcInst:
+		e.writeIndent()
+		e.markParentChild()
+		e.writer.WriteString("<?")
+		e.writer.WriteString(t.Target)
+		e.writer.WriteByte(' ')
+		e.writer.Write(t.Inst)
+		e.writer.WriteString("?>")
+		return nil
+	case xml.Directive:
+		e.writeIndent()
+		e.markParentChild()
+		e.writer.WriteByte('<')
+		e.writer.WriteByte('!')
+		e.writer.Write(t)
+		e.writer.WriteByte('>')
+		return nil
+	}
+	return nil
+}
+
+func (e *Encoder) encodeStart(t xml.StartElement) error {
+	e.writeIndent()
+	e.markParentChild()
+
+	e.writer.WriteByte('<')
+	e.writer.WriteString(e.tagName(t.Name))
+	for _, attr := range t.Attr {
+		e.writer.WriteByte(' ')
+		e.writer.WriteString(e.tagName(attr.Name))
+		e.writer.WriteByte('=')
+		e.writer.WriteByte('"')
+		xml.Escape(e.writer, []byte(attr.Value))
+		e.writer.WriteByte('"')
+	}
+	e.writer.WriteByte('>')
+
+	e.stack = append(e.stack, encoderFrame{name: t.Name})
+	return nil
+}
+
+func (e *Encoder) encodeEnd(name xml.Name) error {
+	if len(e.stack) == 0 {
+		return errors.New("xmlutil: EncodeToken: end element " + name.Local + " without matching start element")
+	}
+	top := e.stack[len(e.stack)-1]
+	if top.name != name {
+		return errors.New("xmlutil: EncodeToken: end element " + name.Local + " does not match start element " + top.name.Local)
+	}
+	depth := len(e.stack) - 1
+	e.stack = e.stack[:len(e.stack)-1]
+
+	if top.hasChild && !top.hasText {
+		e.writeIndentAt(depth)
+	}
+	e.writer.WriteByte('<')
+	e.writer.WriteByte('/')
+	e.writer.WriteString(e.tagName(name))
+	e.writer.WriteByte('>')
+	return nil
+}
+
+func (e *Encoder) tagName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	if prefix := e.xmlutil.lookupPrefix(name.Space); prefix != "" {
+		return prefix + ":" + name.Local
+	}
+	return name.Local
+}
+
+func (e *Encoder) markParentChild() {
+	if len(e.stack) > 0 {
+		e.stack[len(e.stack)-1].hasChild = true
+	}
+}
+
+func (e *Encoder) writeIndent() {
+	if len(e.stack) == 0 {
+		return
+	}
+	e.writeIndentAt(len(e.stack))
+}
+
+// writeIndentAt writes a newline followed by prefix and depth copies of
+// indent, regardless of the current stack depth. encodeEnd uses this
+// directly (rather than writeIndent) because a closing tag's indentation
+// depth is one less than the stack depth at the time it's written,
+// including the outermost element's closing tag at depth 0, which still
+// gets a newline even though writeIndent's depth-0 guard would otherwise
+// suppress it.
+func (e *Encoder) writeIndentAt(depth int) {
+	if e.indent == "" && e.prefix == "" {
+		return
+	}
+	e.writer.WriteByte('\n')
+	e.writer.WriteString(e.prefix)
+	for i := 0; i < depth; i++ {
+		e.writer.WriteString(e.indent)
+	}
+}
+
+func marshalerFor(val reflect.Value) (Marshaler, bool) {
+	if val.CanAddr() {
+		if m, ok := val.Addr().Interface().(Marshaler); ok {
+			return m, true
+		}
+	}
+	if val.CanInterface() {
+		if m, ok := val.Interface().(Marshaler); ok {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+func (e *Encoder) startElementFor(typ reflect.Type, name *xml.Name) (xml.StartElement, error) {
+	ti, err := e.xmlutil.getTypeInfo(typ)
+	if err != nil {
+		return xml.StartElement{}, err
+	}
+	if name == nil {
+		name = &ti.name
+	}
+	return xml.StartElement{Name: *name}, nil
+}
+
 func (e *Encoder) marshalValue(val reflect.Value, name *xml.Name) error {
 	if !val.IsValid() {
 		return nil
@@ -49,10 +247,19 @@ func (e *Encoder) marshalValue(val reflect.Value, name *xml.Name) error {
 	kind := val.Kind()
 	typ := val.Type()
 
-	if kind == reflect.Ptr || kind == reflect.Interface {
-		if val.IsNil() {
-			return nil
+	if (kind == reflect.Ptr || kind == reflect.Interface) && val.IsNil() {
+		return nil
+	}
+
+	if m, ok := marshalerFor(val); ok {
+		start, err := e.startElementFor(typ, name)
+		if err != nil {
+			return err
 		}
+		return m.MarshalXML(e, start)
+	}
+
+	if kind == reflect.Ptr || kind == reflect.Interface {
 		return e.marshalValue(val.Elem(), name)
 	}
 
@@ -74,22 +281,17 @@ func (e *Encoder) marshalValue(val reflect.Value, name *xml.Name) error {
 	if name == nil {
 		name = &ti.name
 	}
-	tag := name.Local
-	if name.Space != "" {
-		if prefix := e.xmlutil.lookupPrefix(name.Space); prefix != "" {
-			tag = prefix + ":" + tag
-		}
-	}
 
-	e.writer.WriteByte('<')
-	e.writer.WriteString(tag)
-	err = e.marshalAttributes(val, ti)
+	attrs, err := e.collectAttrs(val, ti)
 	if err != nil {
-		return nil
+		return err
+	}
+
+	if err := e.EncodeToken(xml.StartElement{Name: *name, Attr: attrs}); err != nil {
+		return err
 	}
-	e.writer.WriteByte('>')
 
-	if kind == reflect.Struct {
+	if _, ok := textMarshalerFor(val); !ok && kind == reflect.Struct {
 		err = e.marshalFields(val, ti)
 	} else {
 		err = e.marshalText(val, ti)
@@ -98,16 +300,12 @@ func (e *Encoder) marshalValue(val reflect.Value, name *xml.Name) error {
 		return err
 	}
 
-	e.writer.WriteByte('<')
-	e.writer.WriteByte('/')
-	e.writer.WriteString(tag)
-	e.writer.WriteByte('>')
-
-	return nil
+	return e.EncodeToken(xml.EndElement{Name: *name})
 }
 
-func (e *Encoder) marshalAttributes(val reflect.Value, ti *typeInfo) error {
-	check := make(map[xml.Name]bool) //string
+func (e *Encoder) collectAttrs(val reflect.Value, ti *typeInfo) ([]xml.Attr, error) {
+	var attrs []xml.Attr
+	check := make(map[xml.Name]bool)
 
 	for _, fi := range ti.fields {
 		if fi.flags&fAttr == 0 {
@@ -116,23 +314,16 @@ func (e *Encoder) marshalAttributes(val reflect.Value, ti *typeInfo) error {
 		if check[fi.name] {
 			continue
 		}
-		check[fi.name] = true
-		var tag string
-		if prefix := e.xmlutil.lookupPrefix(fi.name.Space); prefix != "" {
-			tag = prefix + ":" + fi.name.Local
-		} else {
-			tag = fi.name.Local
-		}
-		e.writer.WriteByte(' ')
-		e.writer.WriteString(tag)
-		e.writer.WriteByte('=')
-		e.writer.WriteByte('"')
 		fval := val.Field(fi.index)
-		err := e.marshalText(fval, ti)
+		if fi.flags&fOmitEmpty != 0 && isEmptyValue(fval) {
+			continue
+		}
+		check[fi.name] = true
+		attr, err := attrFor(fval, fi.name)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		e.writer.WriteByte('"')
+		attrs = append(attrs, attr)
 	}
 
 	for _, attr := range ti.attrs {
@@ -140,34 +331,101 @@ func (e *Encoder) marshalAttributes(val reflect.Value, ti *typeInfo) error {
 			continue
 		}
 		check[attr.Name] = true
-		var tag string
-		if prefix := e.xmlutil.lookupPrefix(attr.Name.Space); prefix != "" {
-			tag = prefix + ":" + attr.Name.Local
-		} else {
-			tag = attr.Name.Local
-		}
-		e.writer.WriteByte(' ')
-		e.writer.WriteString(tag)
-		e.writer.WriteByte('=')
-		e.writer.WriteByte('"')
-		xml.Escape(e.writer, []byte(attr.Value))
-		e.writer.WriteByte('"')
+		attrs = append(attrs, attr)
 	}
 
-	return nil
+	return attrs, nil
+}
+
+func attrFor(val reflect.Value, name xml.Name) (xml.Attr, error) {
+	if val.CanAddr() {
+		if m, ok := val.Addr().Interface().(MarshalerAttr); ok {
+			return m.MarshalXMLAttr(name)
+		}
+	}
+	if val.CanInterface() {
+		if m, ok := val.Interface().(MarshalerAttr); ok {
+			return m.MarshalXMLAttr(name)
+		}
+	}
+	s, err := textValue(val)
+	if err != nil {
+		return xml.Attr{}, err
+	}
+	return xml.Attr{Name: name, Value: s}, nil
 }
 
 func (e *Encoder) marshalFields(val reflect.Value, ti *typeInfo) error {
+	// openParents is the chain of ">"-wrapper elements left open by the
+	// previous field. Fields are emitted in declaration order, so two
+	// fields sharing a wrapper prefix (xml:"a>foo" and xml:"a>bar") need
+	// only close and reopen the part of the chain that differs, letting
+	// them share a single <a> instead of each opening their own.
+	var openParents []string
 	for _, fi := range ti.fields {
 		if fi.flags&fAttr != 0 {
 			continue
 		}
 		fval := val.Field(fi.index)
-		name := fi.name
 
 		if fi.flags&fOmitEmpty != 0 && isEmptyValue(fval) {
 			continue
 		}
+
+		n := 0
+		for n < len(openParents) && n < len(fi.parents) && openParents[n] == fi.parents[n] {
+			n++
+		}
+		for i := len(openParents) - 1; i >= n; i-- {
+			if err := e.EncodeToken(xml.EndElement{Name: xml.Name{Local: openParents[i]}}); err != nil {
+				return err
+			}
+		}
+		openParents = openParents[:n]
+		for _, parent := range fi.parents[n:] {
+			if err := e.EncodeToken(xml.StartElement{Name: xml.Name{Local: parent}}); err != nil {
+				return err
+			}
+			openParents = append(openParents, parent)
+		}
+
+		switch {
+		case fi.flags&fChardata != 0:
+			s, err := textValue(fval)
+			if err != nil {
+				return err
+			}
+			if err := e.EncodeToken(xml.CharData(s)); err != nil {
+				return err
+			}
+			continue
+		case fi.flags&fInnerXml != 0:
+			e.writeInnerXML(fval)
+			continue
+		case fi.flags&fComment != 0:
+			s, err := textValue(fval)
+			if err != nil {
+				return err
+			}
+			if err := e.EncodeToken(xml.Comment(s)); err != nil {
+				return err
+			}
+			continue
+		case fi.flags&fPropertyBag != 0:
+			bag, _ := fval.Interface().(PropertyBag)
+			if err := e.EncodeToken(xml.StartElement{Name: fi.name}); err != nil {
+				return err
+			}
+			if err := e.marshalPropertyBag(bag); err != nil {
+				return err
+			}
+			if err := e.EncodeToken(xml.EndElement{Name: fi.name}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := fi.name
 		if fi.flags&fInterface != 0 {
 			rti, err := e.xmlutil.getTypeInfo(fval.Elem().Type())
 			if err != nil {
@@ -175,46 +433,151 @@ func (e *Encoder) marshalFields(val reflect.Value, ti *typeInfo) error {
 			}
 			name = rti.name
 		}
-		err := e.marshalValue(fval, &name)
+
+		var err error
+		if fi.flags&fCData != 0 {
+			err = e.marshalCData(fval, name)
+		} else {
+			err = e.marshalValue(fval, &name)
+		}
 		if err != nil {
 			return err
 		}
 	}
+	for i := len(openParents) - 1; i >= 0; i-- {
+		if err := e.EncodeToken(xml.EndElement{Name: xml.Name{Local: openParents[i]}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalCData writes val as <name><![CDATA[...]]></name>, used for fields
+// tagged ",cdata".
+func (e *Encoder) marshalCData(val reflect.Value, name xml.Name) error {
+	s, err := textValue(val)
+	if err != nil {
+		return err
+	}
+	if err := e.EncodeToken(xml.StartElement{Name: name}); err != nil {
+		return err
+	}
+	e.writeRaw([]byte("<![CDATA[" + s + "]]>"))
+	return e.EncodeToken(xml.EndElement{Name: name})
+}
+
+// writeInnerXML writes val's raw bytes verbatim, used for fields tagged
+// ",innerxml". Unlike other fields it is not wrapped in its own element -
+// the raw content stands in for the field's position directly.
+func (e *Encoder) writeInnerXML(val reflect.Value) {
+	switch val.Kind() {
+	case reflect.String:
+		e.writeRaw([]byte(val.String()))
+	case reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeRaw(val.Bytes())
+		}
+	}
+}
+
+// writeRaw writes b to the output unescaped and unindented, marking the
+// current element as having non-element content so Indent doesn't try to
+// reformat it.
+func (e *Encoder) writeRaw(b []byte) {
+	if len(e.stack) > 0 {
+		top := len(e.stack) - 1
+		e.stack[top].hasChild = true
+		e.stack[top].hasText = true
+	}
+	e.writer.Write(b)
+}
+
+// marshalPropertyBag writes each of a PropertyBag's entries as its own
+// element, in the order they were added. The caller is responsible for the
+// wrapping element, e.g. marshalFields wraps it in the field's own tag. It
+// recurses through marshalValue so ordinary registered Go values
+// round-trip, while entries captured verbatim as RawXML are re-emitted
+// as-is.
+func (e *Encoder) marshalPropertyBag(bag PropertyBag) error {
+	for _, name := range bag.order {
+		entry := bag.Values[name]
+		n := name
+		if raw, ok := entry.(RawXML); ok {
+			if err := e.EncodeToken(xml.StartElement{Name: n}); err != nil {
+				return err
+			}
+			e.writeRaw(raw)
+			if err := e.EncodeToken(xml.EndElement{Name: n}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := e.marshalValue(reflect.ValueOf(entry), &n); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 var timeType = reflect.TypeOf(time.Time{})
 
-func (e *Encoder) marshalText(val reflect.Value, ti *typeInfo) error {
+func textMarshalerFor(val reflect.Value) (encoding.TextMarshaler, bool) {
+	if val.CanAddr() {
+		if m, ok := val.Addr().Interface().(encoding.TextMarshaler); ok {
+			return m, true
+		}
+	}
+	if val.CanInterface() {
+		if m, ok := val.Interface().(encoding.TextMarshaler); ok {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+func textValue(val reflect.Value) (string, error) {
+	if m, ok := textMarshalerFor(val); ok {
+		b, err := m.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
 	if val.Type() == timeType {
-		e.writer.WriteString(val.Interface().(time.Time).Format(time.RFC3339Nano))
-		return nil
+		return val.Interface().(time.Time).Format(time.RFC3339Nano), nil
 	}
 	switch val.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		e.writer.WriteString(strconv.FormatInt(val.Int(), 10))
+		return strconv.FormatInt(val.Int(), 10), nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		e.writer.WriteString(strconv.FormatUint(val.Uint(), 10))
+		return strconv.FormatUint(val.Uint(), 10), nil
 	case reflect.Float32, reflect.Float64:
-		e.writer.WriteString(strconv.FormatFloat(val.Float(), 'g', -1, 64))
+		return strconv.FormatFloat(val.Float(), 'g', -1, 64), nil
 	case reflect.String:
-		xml.Escape(e.writer, []byte(val.String()))
+		return val.String(), nil
 	case reflect.Bool:
-		e.writer.WriteString(strconv.FormatBool(val.Bool()))
+		return strconv.FormatBool(val.Bool()), nil
 	case reflect.Array:
 		// will be [...]byte
 		bytes := make([]byte, val.Len())
 		for i := range bytes {
 			bytes[i] = val.Index(i).Interface().(byte)
 		}
-		xml.Escape(e.writer, bytes)
+		return string(bytes), nil
 	case reflect.Slice:
 		// will be []byte
-		xml.Escape(e.writer, val.Bytes())
+		return string(val.Bytes()), nil
 	default:
-		return &UnsupportedTypeError{val.Type()}
+		return "", &UnsupportedTypeError{val.Type()}
 	}
-	return nil
+}
+
+func (e *Encoder) marshalText(val reflect.Value, ti *typeInfo) error {
+	s, err := textValue(val)
+	if err != nil {
+		return err
+	}
+	return e.EncodeToken(xml.CharData(s))
 }
 
 func isEmptyValue(v reflect.Value) (empty bool) {
@@ -0,0 +1,26 @@
+package xmlutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+type indentHolder struct {
+	Name string `xml:"name"`
+}
+
+func TestEncoderIndent(t *testing.T) {
+	x := NewXmlUtil()
+
+	var buf bytes.Buffer
+	e := x.NewEncoder(&buf)
+	e.Indent("", "  ")
+	if err := e.Encode(&indentHolder{Name: "a"}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	const want = "<indentHolder>\n  <name>a</name>\n</indentHolder>"
+	if got := buf.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,40 @@
+package xmlutil
+
+import (
+	"strings"
+	"testing"
+)
+
+type innerXMLHolder struct {
+	Name string `xml:"name"`
+	Raw  string `xml:",innerxml"`
+}
+
+func TestInnerXMLAnyReader(t *testing.T) {
+	x := NewXmlUtil()
+	const doc = `<holder><name>a</name><extra>1</extra><more>2</more></holder>`
+
+	var out innerXMLHolder
+	if err := x.NewDecoder(strings.NewReader(doc)).Decode(&out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if out.Name != "a" {
+		t.Fatalf("Name = %q, want %q", out.Name, "a")
+	}
+	if !strings.Contains(out.Raw, "<extra>1</extra>") || !strings.Contains(out.Raw, "<more>2</more>") {
+		t.Fatalf("Raw = %q, want it to contain the extra and more elements", out.Raw)
+	}
+}
+
+func TestInnerXMLUnmarshal(t *testing.T) {
+	x := NewXmlUtil()
+	const doc = `<holder><name>a</name><extra>1</extra></holder>`
+
+	var out innerXMLHolder
+	if err := x.Unmarshal([]byte(doc), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !strings.Contains(out.Raw, "<extra>1</extra>") {
+		t.Fatalf("Raw = %q, want it to contain <extra>1</extra>", out.Raw)
+	}
+}
@@ -0,0 +1,59 @@
+package xmlutil
+
+import (
+	"encoding/xml"
+	"errors"
+	"testing"
+)
+
+type ifaceAnimal struct {
+	Name string `xml:"name"`
+}
+
+type ifaceHolder struct {
+	Pet interface{} `xml:"pet"`
+}
+
+type ifaceAnyHolder struct {
+	Pet interface{} `xml:"pet,any"`
+}
+
+func TestInterfaceDecodeRegisteredType(t *testing.T) {
+	x := NewXmlUtil()
+	x.RegisterTypeMore(ifaceAnimal{}, xml.Name{Local: "cat"}, nil)
+
+	var out ifaceHolder
+	if err := x.Unmarshal([]byte(`<ifaceHolder><cat><name>Tom</name></cat></ifaceHolder>`), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	animal, ok := out.Pet.(ifaceAnimal)
+	if !ok {
+		t.Fatalf("Pet = %#v, want ifaceAnimal", out.Pet)
+	}
+	if animal.Name != "Tom" {
+		t.Fatalf("Name = %q, want %q", animal.Name, "Tom")
+	}
+}
+
+func TestInterfaceDecodeUnknownTypeError(t *testing.T) {
+	x := NewXmlUtil()
+
+	var out ifaceHolder
+	err := x.Unmarshal([]byte(`<ifaceHolder><cat><name>Tom</name></cat></ifaceHolder>`), &out)
+	var ute *UnknownTypeError
+	if !errors.As(err, &ute) {
+		t.Fatalf("err = %v, want *UnknownTypeError", err)
+	}
+}
+
+func TestInterfaceDecodeAnySkipsUnknownType(t *testing.T) {
+	x := NewXmlUtil()
+
+	var out ifaceAnyHolder
+	if err := x.Unmarshal([]byte(`<ifaceAnyHolder><cat><name>Tom</name></cat></ifaceAnyHolder>`), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Pet != nil {
+		t.Fatalf("Pet = %#v, want nil", out.Pet)
+	}
+}
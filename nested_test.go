@@ -0,0 +1,45 @@
+package xmlutil
+
+import (
+	"strings"
+	"testing"
+)
+
+type nestedSiblings struct {
+	Foo string `xml:"a>foo"`
+	Bar string `xml:"a>bar"`
+}
+
+func TestNestedSiblingsShareWrapperRoundTrip(t *testing.T) {
+	x := NewXmlUtil()
+
+	in := nestedSiblings{Foo: "1", Bar: "2"}
+	data, err := x.Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if strings.Count(string(data), "<a>") != 1 {
+		t.Fatalf("Marshal output %q, want exactly one <a> wrapper", data)
+	}
+
+	var out nestedSiblings
+	if err := x.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out != in {
+		t.Fatalf("out = %#v, want %#v", out, in)
+	}
+}
+
+func TestNestedSiblingsDecodeSeparateWrappers(t *testing.T) {
+	x := NewXmlUtil()
+	const doc = `<nestedSiblings><a><foo>1</foo></a><a><bar>2</bar></a></nestedSiblings>`
+
+	var out nestedSiblings
+	if err := x.Unmarshal([]byte(doc), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Foo != "1" || out.Bar != "2" {
+		t.Fatalf("out = %#v, want {Foo:1 Bar:2}", out)
+	}
+}
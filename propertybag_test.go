@@ -0,0 +1,97 @@
+package xmlutil
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+type davDisplayName struct {
+	Value string `xml:",chardata"`
+}
+
+type davPropstat struct {
+	Prop   PropertyBag `xml:"prop"`
+	Status string      `xml:"status"`
+}
+
+func TestPropertyBagPropWrapperRoundTrip(t *testing.T) {
+	x := NewXmlUtil()
+	x.RegisterTypeMore(davDisplayName{}, xml.Name{Local: "displayname"}, nil)
+
+	var in davPropstat
+	in.Status = "HTTP/1.1 200 OK"
+	in.Prop.Set(xml.Name{Local: "displayname"}, davDisplayName{Value: "My Folder"})
+	in.Prop.Set(xml.Name{Local: "getcontentlength"}, RawXML("1024"))
+
+	data, err := x.Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	const wantSubstr = "<prop>"
+	if !strings.Contains(string(data), wantSubstr) {
+		t.Fatalf("Marshal output %q does not contain a <prop> wrapper", data)
+	}
+
+	var out davPropstat
+	if err := x.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Status != in.Status {
+		t.Fatalf("Status = %q, want %q", out.Status, in.Status)
+	}
+	name, ok := out.Prop.Get(xml.Name{Local: "displayname"})
+	if !ok {
+		t.Fatalf("Prop missing displayname entry, got %#v", out.Prop)
+	}
+	if dn, ok := name.(davDisplayName); !ok || dn.Value != "My Folder" {
+		t.Fatalf("displayname = %#v, want davDisplayName{Value: \"My Folder\"}", name)
+	}
+	length, ok := out.Prop.Get(xml.Name{Local: "getcontentlength"})
+	if !ok {
+		t.Fatalf("Prop missing getcontentlength entry, got %#v", out.Prop)
+	}
+	if raw, ok := length.(RawXML); !ok || string(raw) != "1024" {
+		t.Fatalf("getcontentlength = %#v, want RawXML(\"1024\")", length)
+	}
+}
+
+type catchAllHolder struct {
+	Name string `xml:"name"`
+	Rest PropertyBag
+}
+
+func TestPropertyBagCatchAllFallbackAnyReader(t *testing.T) {
+	x := NewXmlUtil()
+	const doc = `<catchAllHolder><name>a</name><extra>1</extra></catchAllHolder>`
+
+	var out catchAllHolder
+	if err := x.NewDecoder(strings.NewReader(doc)).Decode(&out); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	entry, ok := out.Rest.Get(xml.Name{Local: "extra"})
+	if !ok {
+		t.Fatalf("Rest missing extra entry, got %#v", out.Rest)
+	}
+	if raw, ok := entry.(RawXML); !ok || string(raw) != "1" {
+		t.Fatalf("extra = %#v, want RawXML(\"1\")", entry)
+	}
+}
+
+func TestPropertyBagTaggedFieldDoesNotCatchSiblings(t *testing.T) {
+	x := NewXmlUtil()
+	x.RegisterTypeMore(davDisplayName{}, xml.Name{Local: "displayname"}, nil)
+	const doc = `<davPropstat><prop><displayname>My Folder</displayname></prop><status>HTTP/1.1 200 OK</status><extra>1</extra></davPropstat>`
+
+	var out davPropstat
+	if err := x.Unmarshal([]byte(doc), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Status != "HTTP/1.1 200 OK" {
+		t.Fatalf("Status = %q, want %q", out.Status, "HTTP/1.1 200 OK")
+	}
+	if _, ok := out.Prop.Get(xml.Name{Local: "extra"}); ok {
+		t.Fatalf("Prop unexpectedly caught unrelated sibling <extra>, got %#v", out.Prop)
+	}
+}
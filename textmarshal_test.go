@@ -0,0 +1,52 @@
+package xmlutil
+
+import (
+	"math/big"
+	"net"
+	"testing"
+)
+
+type ipHolder struct {
+	Addr net.IP `xml:"addr"`
+}
+
+type bigIntHolder struct {
+	N *big.Int `xml:"n"`
+}
+
+func TestTextMarshalerRoundTripSlice(t *testing.T) {
+	x := NewXmlUtil()
+
+	in := ipHolder{Addr: net.ParseIP("192.168.1.1")}
+	data, err := x.Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var out ipHolder
+	if err := x.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !out.Addr.Equal(in.Addr) {
+		t.Fatalf("got %v, want %v", out.Addr, in.Addr)
+	}
+}
+
+func TestTextMarshalerRoundTripStruct(t *testing.T) {
+	x := NewXmlUtil()
+
+	in := bigIntHolder{N: big.NewInt(123456789)}
+	data, err := x.Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var out bigIntHolder
+	out.N = new(big.Int)
+	if err := x.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.N.Cmp(in.N) != 0 {
+		t.Fatalf("got %v, want %v", out.N, in.N)
+	}
+}
@@ -0,0 +1,397 @@
+package xmlrpc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/webconnex/xmlutil"
+)
+
+type param struct {
+	Value Value `xml:"value"`
+}
+
+type fault struct {
+	Value Value `xml:"value"`
+}
+
+type methodCall struct {
+	MethodName string  `xml:"methodName"`
+	Params     []param `xml:"params>param"`
+}
+
+type methodResponse struct {
+	Params []param `xml:"params>param"`
+	Fault  *fault  `xml:"fault"`
+}
+
+// FaultError is returned by Call when the server responds with an
+// XML-RPC <fault>.
+type FaultError struct {
+	Code    int
+	Message string
+}
+
+func (f *FaultError) Error() string {
+	return fmt.Sprintf("xmlrpc: fault %d: %s", f.Code, f.Message)
+}
+
+// Client calls methods on a single XML-RPC endpoint.
+type Client struct {
+	url        string
+	xmlutil    *xmlutil.XmlUtil
+	httpClient *http.Client
+}
+
+// NewClient returns a Client that POSTs XML-RPC requests to url, encoding
+// and decoding the methodCall/methodResponse envelope through x.
+func NewClient(url string, x *xmlutil.XmlUtil) *Client {
+	registerEnvelope(x)
+	return &Client{url: url, xmlutil: x, httpClient: http.DefaultClient}
+}
+
+// registerEnvelope registers the envelope types on x, tolerating the case
+// where a Client for the same XmlUtil was already created.
+func registerEnvelope(x *xmlutil.XmlUtil) {
+	for _, v := range []struct {
+		value interface{}
+		name  string
+	}{
+		{methodCall{}, "methodCall"},
+		{methodResponse{}, "methodResponse"},
+		{param{}, "param"},
+		{fault{}, "fault"},
+	} {
+		registerOnce(x, v.value, v.name)
+	}
+}
+
+func registerOnce(x *xmlutil.XmlUtil, value interface{}, name string) {
+	defer func() { recover() }()
+	x.RegisterTypeMore(value, xml.Name{Local: name}, nil)
+}
+
+// Call invokes method on the server with args marshaled as the call's
+// parameters and unmarshals the first returned parameter into reply. If
+// args is a slice or array, each element becomes its own parameter;
+// otherwise args itself becomes the sole parameter. reply may be nil to
+// discard the result.
+func (c *Client) Call(method string, args interface{}, reply interface{}) error {
+	params, err := paramsFromArgs(args)
+	if err != nil {
+		return err
+	}
+
+	body := []byte(xmlutil.Header)
+	b, err := c.xmlutil.Marshal(&methodCall{MethodName: method, Params: params})
+	if err != nil {
+		return err
+	}
+	body = append(body, b...)
+
+	resp, err := c.httpClient.Post(c.url, "text/xml", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var mr methodResponse
+	if err := c.xmlutil.Unmarshal(data, &mr); err != nil {
+		return err
+	}
+
+	if mr.Fault != nil {
+		code, _ := structMember(mr.Fault.Value, "faultCode")
+		msg, _ := structMember(mr.Fault.Value, "faultString")
+		faultCode := 0
+		if code.Int != nil {
+			faultCode = *code.Int
+		}
+		faultMsg := ""
+		if msg.String != nil {
+			faultMsg = *msg.String
+		}
+		return &FaultError{Code: faultCode, Message: faultMsg}
+	}
+
+	if reply == nil || len(mr.Params) == 0 {
+		return nil
+	}
+
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() != reflect.Ptr {
+		return errors.New("xmlrpc: non-pointer passed as reply")
+	}
+	return assignValue(rv.Elem(), mr.Params[0].Value)
+}
+
+func structMember(v Value, name string) (Value, bool) {
+	for _, m := range v.Struct {
+		if m.Name == name {
+			return m.Value, true
+		}
+	}
+	return Value{}, false
+}
+
+func paramsFromArgs(args interface{}) ([]param, error) {
+	if args == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(args)
+	var items []reflect.Value
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, v.Index(i))
+		}
+	} else {
+		items = append(items, v)
+	}
+
+	params := make([]param, len(items))
+	for i, item := range items {
+		val, err := goToValue(item)
+		if err != nil {
+			return nil, err
+		}
+		params[i] = param{Value: val}
+	}
+	return params, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// goToValue converts a Go value into its xmlrpc.Value representation,
+// following the same struct/slice/time.Time/[]byte conventions xmlutil's
+// Decoder uses when going the other way.
+func goToValue(v reflect.Value) (Value, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return NewString(""), nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == timeType {
+		return NewDateTime(v.Interface().(time.Time)), nil
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		return NewBase64(v.Bytes()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInt(int(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return NewInt(int(v.Uint())), nil
+	case reflect.Bool:
+		return NewBoolean(v.Bool()), nil
+	case reflect.String:
+		return NewString(v.String()), nil
+	case reflect.Float32, reflect.Float64:
+		return NewDouble(v.Float()), nil
+	case reflect.Slice, reflect.Array:
+		items := make([]Value, v.Len())
+		for i := range items {
+			item, err := goToValue(v.Index(i))
+			if err != nil {
+				return Value{}, err
+			}
+			items[i] = item
+		}
+		return NewArray(items), nil
+	case reflect.Map:
+		var members []Member
+		for _, key := range v.MapKeys() {
+			item, err := goToValue(v.MapIndex(key))
+			if err != nil {
+				return Value{}, err
+			}
+			members = append(members, Member{Name: fmt.Sprint(key.Interface()), Value: item})
+		}
+		return NewStruct(members...), nil
+	case reflect.Struct:
+		var members []Member
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			item, err := goToValue(v.Field(i))
+			if err != nil {
+				return Value{}, err
+			}
+			members = append(members, Member{Name: fieldName(f), Value: item})
+		}
+		return NewStruct(members...), nil
+	default:
+		return Value{}, fmt.Errorf("xmlrpc: unsupported arg type %s", v.Type())
+	}
+}
+
+func fieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("xml"), ",")[0]
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// assignValue decodes v into dst, following the same reflection rules as
+// xmlutil.Decoder: structs decode from <struct>, slices from <array>,
+// time.Time from <dateTime.iso8601> and []byte from <base64>.
+func assignValue(dst reflect.Value, v Value) error {
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assignValue(dst.Elem(), v)
+	}
+
+	switch {
+	case v.Int != nil:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			dst.SetInt(int64(*v.Int))
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(*v.Int))
+		case reflect.Interface:
+			dst.Set(reflect.ValueOf(*v.Int))
+		default:
+			return fmt.Errorf("xmlrpc: cannot assign int into %s", dst.Type())
+		}
+	case v.Boolean != nil:
+		switch dst.Kind() {
+		case reflect.Bool:
+			dst.SetBool(*v.Boolean)
+		case reflect.Interface:
+			dst.Set(reflect.ValueOf(*v.Boolean))
+		default:
+			return fmt.Errorf("xmlrpc: cannot assign boolean into %s", dst.Type())
+		}
+	case v.Double != nil:
+		switch dst.Kind() {
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(*v.Double)
+		case reflect.Interface:
+			dst.Set(reflect.ValueOf(*v.Double))
+		default:
+			return fmt.Errorf("xmlrpc: cannot assign double into %s", dst.Type())
+		}
+	case v.DateTime != nil:
+		switch {
+		case dst.Type() == timeType:
+			dst.Set(reflect.ValueOf(*v.DateTime))
+		case dst.Kind() == reflect.Interface:
+			dst.Set(reflect.ValueOf(*v.DateTime))
+		default:
+			return fmt.Errorf("xmlrpc: cannot assign dateTime.iso8601 into %s", dst.Type())
+		}
+	case v.Base64 != nil:
+		switch {
+		case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8:
+			dst.SetBytes(v.Base64)
+		case dst.Kind() == reflect.Interface:
+			dst.Set(reflect.ValueOf(v.Base64))
+		default:
+			return fmt.Errorf("xmlrpc: cannot assign base64 into %s", dst.Type())
+		}
+	case v.Array != nil:
+		return assignArray(dst, v.Array)
+	case v.Struct != nil:
+		return assignStruct(dst, v.Struct)
+	case v.String != nil:
+		switch dst.Kind() {
+		case reflect.String:
+			dst.SetString(*v.String)
+		case reflect.Interface:
+			dst.Set(reflect.ValueOf(*v.String))
+		default:
+			return fmt.Errorf("xmlrpc: cannot assign string into %s", dst.Type())
+		}
+	}
+	return nil
+}
+
+func assignArray(dst reflect.Value, items []Value) error {
+	switch dst.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := assignValue(s.Index(i), item); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Interface:
+		s := make([]interface{}, len(items))
+		for i, item := range items {
+			var elem interface{}
+			if err := assignValue(reflect.ValueOf(&elem).Elem(), item); err != nil {
+				return err
+			}
+			s[i] = elem
+		}
+		dst.Set(reflect.ValueOf(s))
+	default:
+		return fmt.Errorf("xmlrpc: cannot assign array into %s", dst.Type())
+	}
+	return nil
+}
+
+func assignStruct(dst reflect.Value, members []Member) error {
+	switch dst.Kind() {
+	case reflect.Struct:
+		t := dst.Type()
+		for _, m := range members {
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if f.PkgPath != "" {
+					continue
+				}
+				if fieldName(f) == m.Name {
+					if err := assignValue(dst.Field(i), m.Value); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+	case reflect.Map:
+		mv := reflect.MakeMap(dst.Type())
+		for _, m := range members {
+			ev := reflect.New(dst.Type().Elem()).Elem()
+			if err := assignValue(ev, m.Value); err != nil {
+				return err
+			}
+			mv.SetMapIndex(reflect.ValueOf(m.Name), ev)
+		}
+		dst.Set(mv)
+	case reflect.Interface:
+		mm := make(map[string]interface{})
+		for _, m := range members {
+			var elem interface{}
+			if err := assignValue(reflect.ValueOf(&elem).Elem(), m.Value); err != nil {
+				return err
+			}
+			mm[m.Name] = elem
+		}
+		dst.Set(reflect.ValueOf(mm))
+	default:
+		return fmt.Errorf("xmlrpc: cannot assign struct into %s", dst.Type())
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package xmlrpc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/webconnex/xmlutil"
+)
+
+func TestClientCallRoundTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("read request body: %v", err)
+		}
+
+		x := xmlutil.NewXmlUtil()
+		registerEnvelope(x)
+		var call methodCall
+		if err := x.Unmarshal(body, &call); err != nil {
+			t.Fatalf("unmarshal methodCall: %v", err)
+		}
+		if call.MethodName != "echo" {
+			t.Fatalf("MethodName = %q, want %q", call.MethodName, "echo")
+		}
+		if len(call.Params) != 1 || call.Params[0].Value.String == nil || *call.Params[0].Value.String != "hello" {
+			t.Fatalf("unexpected params: %+v", call.Params)
+		}
+
+		resp := methodResponse{Params: []param{{Value: NewString("hello")}}}
+		data, err := x.Marshal(&resp)
+		if err != nil {
+			t.Fatalf("marshal methodResponse: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(xmlutil.Header))
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, xmlutil.NewXmlUtil())
+
+	var reply string
+	if err := client.Call("echo", "hello", &reply); err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if reply != "hello" {
+		t.Fatalf("reply = %q, want %q", reply, "hello")
+	}
+}
+
+func TestClientCallFault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x := xmlutil.NewXmlUtil()
+		registerEnvelope(x)
+		resp := methodResponse{Fault: &fault{Value: NewStruct(
+			Member{Name: "faultCode", Value: NewInt(4)},
+			Member{Name: "faultString", Value: NewString("too many parameters")},
+		)}}
+		data, err := x.Marshal(&resp)
+		if err != nil {
+			t.Fatalf("marshal methodResponse: %v", err)
+		}
+		w.Write([]byte(xmlutil.Header))
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, xmlutil.NewXmlUtil())
+
+	var reply string
+	err := client.Call("echo", "hello", &reply)
+	fe, ok := err.(*FaultError)
+	if !ok {
+		t.Fatalf("err = %v, want *FaultError", err)
+	}
+	if fe.Code != 4 || fe.Message != "too many parameters" {
+		t.Fatalf("fault = %+v, want {4 too many parameters}", fe)
+	}
+}
@@ -0,0 +1,365 @@
+// Package xmlrpc implements the XML-RPC wire format on top of xmlutil.
+package xmlrpc
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webconnex/xmlutil"
+)
+
+const dateTimeLayout = "20060102T15:04:05"
+
+// Member is a named entry of a <struct> Value. Members are kept in a slice,
+// rather than a map, so the order they were added in is preserved on the
+// wire.
+type Member struct {
+	Name  string
+	Value Value
+}
+
+// Value is an XML-RPC <value> element: a discriminated union of the
+// protocol's scalar and compound types. The constructors, and decoding
+// any of the recognised types, populate exactly one field; decoding an
+// unrecognised typed leaf populates none. A Value with every field at its
+// zero value encodes as an empty XML-RPC string.
+type Value struct {
+	Int      *int
+	Boolean  *bool
+	String   *string
+	Double   *float64
+	DateTime *time.Time
+	Base64   []byte
+	Array    []Value
+	Struct   []Member
+}
+
+func NewInt(v int) Value            { return Value{Int: &v} }
+func NewBoolean(v bool) Value       { return Value{Boolean: &v} }
+func NewString(v string) Value      { return Value{String: &v} }
+func NewDouble(v float64) Value     { return Value{Double: &v} }
+func NewDateTime(v time.Time) Value { return Value{DateTime: &v} }
+func NewBase64(v []byte) Value      { return Value{Base64: v} }
+func NewArray(v []Value) Value      { return Value{Array: v} }
+
+func NewStruct(members ...Member) Value { return Value{Struct: members} }
+
+// MarshalXML implements xmlutil.Marshaler. It writes the <value> element
+// and recurses into xmlutil's streaming Encoder for nested arrays and
+// structs.
+func (v Value) MarshalXML(e *xmlutil.Encoder, start xml.StartElement) error {
+	if start.Name.Local == "" {
+		start.Name = xml.Name{Local: "value"}
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := v.marshalInner(e); err != nil {
+		return err
+	}
+	return e.EncodeToken(xml.EndElement{Name: start.Name})
+}
+
+func (v Value) marshalInner(e *xmlutil.Encoder) error {
+	switch {
+	case v.Int != nil:
+		return writeLeaf(e, "int", strconv.Itoa(*v.Int))
+	case v.Boolean != nil:
+		b := "0"
+		if *v.Boolean {
+			b = "1"
+		}
+		return writeLeaf(e, "boolean", b)
+	case v.Double != nil:
+		return writeLeaf(e, "double", strconv.FormatFloat(*v.Double, 'g', -1, 64))
+	case v.DateTime != nil:
+		return writeLeaf(e, "dateTime.iso8601", v.DateTime.UTC().Format(dateTimeLayout))
+	case v.Base64 != nil:
+		return writeLeaf(e, "base64", base64.StdEncoding.EncodeToString(v.Base64))
+	case v.Array != nil:
+		return v.marshalArray(e)
+	case v.Struct != nil:
+		return v.marshalStruct(e)
+	case v.String != nil:
+		return writeLeaf(e, "string", *v.String)
+	default:
+		return writeLeaf(e, "string", "")
+	}
+}
+
+func (v Value) marshalArray(e *xmlutil.Encoder) error {
+	if err := e.EncodeToken(xml.StartElement{Name: xml.Name{Local: "array"}}); err != nil {
+		return err
+	}
+	if err := e.EncodeToken(xml.StartElement{Name: xml.Name{Local: "data"}}); err != nil {
+		return err
+	}
+	for _, item := range v.Array {
+		if err := item.MarshalXML(e, xml.StartElement{Name: xml.Name{Local: "value"}}); err != nil {
+			return err
+		}
+	}
+	if err := e.EncodeToken(xml.EndElement{Name: xml.Name{Local: "data"}}); err != nil {
+		return err
+	}
+	return e.EncodeToken(xml.EndElement{Name: xml.Name{Local: "array"}})
+}
+
+func (v Value) marshalStruct(e *xmlutil.Encoder) error {
+	if err := e.EncodeToken(xml.StartElement{Name: xml.Name{Local: "struct"}}); err != nil {
+		return err
+	}
+	for _, m := range v.Struct {
+		if err := e.EncodeToken(xml.StartElement{Name: xml.Name{Local: "member"}}); err != nil {
+			return err
+		}
+		if err := writeLeaf(e, "name", m.Name); err != nil {
+			return err
+		}
+		if err := m.Value.MarshalXML(e, xml.StartElement{Name: xml.Name{Local: "value"}}); err != nil {
+			return err
+		}
+		if err := e.EncodeToken(xml.EndElement{Name: xml.Name{Local: "member"}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(xml.EndElement{Name: xml.Name{Local: "struct"}})
+}
+
+func writeLeaf(e *xmlutil.Encoder, name, text string) error {
+	n := xml.Name{Local: name}
+	if err := e.EncodeToken(xml.StartElement{Name: n}); err != nil {
+		return err
+	}
+	if err := e.EncodeToken(xml.CharData(text)); err != nil {
+		return err
+	}
+	return e.EncodeToken(xml.EndElement{Name: n})
+}
+
+// UnmarshalXML implements xmlutil.Unmarshaler, reading the typed leaf (or
+// bare character data) nested inside a <value> element.
+func (v *Value) UnmarshalXML(d *xmlutil.Decoder, start xml.StartElement) error {
+	var text []byte
+	typed := false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			typed = true
+			if err := v.decodeTyped(d, t); err != nil {
+				return err
+			}
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			if !typed {
+				s := string(text)
+				v.String = &s
+			}
+			return nil
+		}
+	}
+}
+
+func (v *Value) decodeTyped(d *xmlutil.Decoder, start xml.StartElement) error {
+	switch start.Name.Local {
+	case "int", "i4":
+		s, err := readText(d)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		v.Int = &n
+	case "boolean":
+		s, err := readText(d)
+		if err != nil {
+			return err
+		}
+		b := strings.TrimSpace(s) == "1"
+		v.Boolean = &b
+	case "string":
+		s, err := readText(d)
+		if err != nil {
+			return err
+		}
+		v.String = &s
+	case "double":
+		s, err := readText(d)
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return err
+		}
+		v.Double = &f
+	case "dateTime.iso8601":
+		s, err := readText(d)
+		if err != nil {
+			return err
+		}
+		t, err := time.Parse(dateTimeLayout, strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		v.DateTime = &t
+	case "base64":
+		s, err := readText(d)
+		if err != nil {
+			return err
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		v.Base64 = b
+	case "array":
+		return v.decodeArray(d)
+	case "struct":
+		return v.decodeStruct(d)
+	default:
+		return d.Skip()
+	}
+	return nil
+}
+
+// readText reads character data up to the matching end element for a
+// start element that has already been consumed from the stream.
+func readText(d *xmlutil.Decoder) (string, error) {
+	var buf []byte
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.CharData:
+			if depth == 0 {
+				buf = append(buf, t...)
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				return string(buf), nil
+			}
+			depth--
+		}
+	}
+}
+
+func (v *Value) decodeArray(d *xmlutil.Decoder) error {
+	items := []Value{}
+Loop:
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "data" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+		DataLoop:
+			for {
+				inner, err := d.Token()
+				if err != nil {
+					return err
+				}
+				switch it := inner.(type) {
+				case xml.StartElement:
+					if it.Name.Local == "value" {
+						var item Value
+						if err := item.UnmarshalXML(d, it); err != nil {
+							return err
+						}
+						items = append(items, item)
+					} else if err := d.Skip(); err != nil {
+						return err
+					}
+				case xml.EndElement:
+					break DataLoop
+				}
+			}
+		case xml.EndElement:
+			break Loop
+		}
+	}
+	v.Array = items
+	return nil
+}
+
+func (v *Value) decodeStruct(d *xmlutil.Decoder) error {
+	members := []Member{}
+Loop:
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "member" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			m, err := decodeMember(d)
+			if err != nil {
+				return err
+			}
+			members = append(members, m)
+		case xml.EndElement:
+			break Loop
+		}
+	}
+	v.Struct = members
+	return nil
+}
+
+func decodeMember(d *xmlutil.Decoder) (Member, error) {
+	var m Member
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return m, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "name":
+				s, err := readText(d)
+				if err != nil {
+					return m, err
+				}
+				m.Name = s
+			case "value":
+				if err := m.Value.UnmarshalXML(d, t); err != nil {
+					return m, err
+				}
+			default:
+				if err := d.Skip(); err != nil {
+					return m, err
+				}
+			}
+		case xml.EndElement:
+			return m, nil
+		}
+	}
+}
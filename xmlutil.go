@@ -9,6 +9,7 @@ import (
 
 type XmlUtil struct {
 	typeMap     map[reflect.Type]*typeInfo
+	nameMap     map[xml.Name]reflect.Type
 	typeLock    sync.RWMutex
 	nsPrefixMap map[string]string
 	nsUriMap    map[string]string
@@ -24,9 +25,17 @@ type typeInfo struct {
 type fieldFlags int
 
 type fieldInfo struct {
-	index int
-	name  xml.Name
-	flags fieldFlags
+	index   int
+	name    xml.Name
+	flags   fieldFlags
+	parents []string
+	// explicitName is true when the field's xml tag gave an element name
+	// explicitly, as opposed to name defaulting to the Go field name. It
+	// distinguishes an untagged PropertyBag, which catches any unmatched
+	// sibling element, from a PropertyBag tagged with a wrapper name like
+	// `xml:"prop"`, which only matches that name unless its tag also
+	// carries ",any".
+	explicitName bool
 }
 
 const (
@@ -34,11 +43,61 @@ const (
 	fAttr
 	fInterface
 	fOmitEmpty
+	fChardata
+	fInnerXml
+	fCData
+	fComment
+	fAny
+	fPropertyBag
 )
 
+// RawXML holds a verbatim, already-serialized XML fragment. It is used by
+// PropertyBag to hold the inner content of a child element whose type
+// isn't registered with the XmlUtil, so it can still be re-emitted as-is.
+type RawXML []byte
+
+// PropertyBag holds heterogeneous, namespace-qualified child elements
+// whose concrete Go types are only known at runtime, such as the
+// properties of a WebDAV PROPFIND response. Entries preserve the order
+// they were added or decoded in. A struct field of this type is tagged
+// like any other, e.g. `xml:"prop"` for a WebDAV <prop> block - that tag
+// names the wrapping element, and every child inside it becomes a bag
+// entry.
+type PropertyBag struct {
+	Values map[xml.Name]interface{}
+	order  []xml.Name
+}
+
+// Set adds or replaces the entry named name. New names are appended to
+// the bag's iteration order; replacing an existing name keeps its
+// original position.
+func (b *PropertyBag) Set(name xml.Name, value interface{}) {
+	if b.Values == nil {
+		b.Values = make(map[xml.Name]interface{})
+	}
+	if _, ok := b.Values[name]; !ok {
+		b.order = append(b.order, name)
+	}
+	b.Values[name] = value
+}
+
+// Get returns the entry named name, if present.
+func (b *PropertyBag) Get(name xml.Name) (interface{}, bool) {
+	v, ok := b.Values[name]
+	return v, ok
+}
+
+// Names returns the bag's entry names in the order they were added.
+func (b *PropertyBag) Names() []xml.Name {
+	return b.order
+}
+
+var propertyBagType = reflect.TypeOf(PropertyBag{})
+
 func NewXmlUtil() *XmlUtil {
 	return &XmlUtil{
 		typeMap:     make(map[reflect.Type]*typeInfo),
+		nameMap:     make(map[xml.Name]reflect.Type),
 		nsPrefixMap: map[string]string{"xmlns": "xmlns"},
 		nsUriMap:    map[string]string{"xmlns": "xmlns"},
 	}
@@ -76,6 +135,7 @@ func (x *XmlUtil) registerType(typ reflect.Type, name xml.Name, attrs []xml.Attr
 
 	x.typeLock.Lock()
 	x.typeMap[typ] = ti
+	x.nameMap[ti.name] = typ
 	x.typeLock.Unlock()
 	return ti, nil
 }
@@ -98,12 +158,7 @@ func (x *XmlUtil) getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 func (x *XmlUtil) getTypeByName(name xml.Name) reflect.Type {
 	x.typeLock.RLock()
 	defer x.typeLock.RUnlock()
-	for typ, ti := range x.typeMap {
-		if ti.name == name {
-			return typ
-		}
-	}
-	return nil
+	return x.nameMap[name]
 }
 
 func (x *XmlUtil) getFields(typ reflect.Type) []fieldInfo {
@@ -124,11 +179,17 @@ func (x *XmlUtil) getFields(typ reflect.Type) []fieldInfo {
 		tokens := strings.Split(f.Tag.Get("xml"), ",")
 		tag := tokens[0]
 
+		if parts := strings.Split(tag, ">"); len(parts) > 1 {
+			fi.parents = parts[:len(parts)-1]
+			tag = parts[len(parts)-1]
+		}
+
 		if i := strings.Index(tag, ":"); i >= 0 {
 			fi.name.Space, fi.name.Local = x.lookupNamespaceUri(tag[:i]), tag[i+1:]
 		} else {
 			fi.name.Local = tag
 		}
+		fi.explicitName = fi.name.Local != ""
 		if fi.name.Local == "" {
 			fi.name.Local = f.Name
 		}
@@ -138,6 +199,16 @@ func (x *XmlUtil) getFields(typ reflect.Type) []fieldInfo {
 				fi.flags |= fAttr
 			case "omitempty":
 				fi.flags |= fOmitEmpty
+			case "chardata":
+				fi.flags |= fChardata
+			case "innerxml":
+				fi.flags |= fInnerXml
+			case "cdata":
+				fi.flags |= fCData
+			case "comment":
+				fi.flags |= fComment
+			case "any":
+				fi.flags |= fAny
 			}
 		}
 		typ := f.Type
@@ -147,6 +218,9 @@ func (x *XmlUtil) getFields(typ reflect.Type) []fieldInfo {
 		if typ.Kind() == reflect.Interface {
 			fi.flags |= fInterface
 		}
+		if typ == propertyBagType {
+			fi.flags |= fPropertyBag
+		}
 		fields = append(fields, fi)
 	}
 	return fields